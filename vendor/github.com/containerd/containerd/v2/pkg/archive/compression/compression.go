@@ -0,0 +1,214 @@
+// Package compression provides a pluggable registry of tar-layer
+// compression formats, so call sites can resolve how to
+// compress/decompress/identify a layer from its media type (or its
+// content) instead of hard-coding a media-type switch themselves.
+package compression
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/containerd/containerd/v2/core/images"
+	"github.com/klauspost/compress/zstd"
+	ocispecs "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Type identifies a single compression format: how to compress and
+// decompress a stream of it, its canonical media type, and how to
+// recognize that media type (or the stream itself) as belonging to it.
+type Type interface {
+	// Compress wraps w so writes to the returned WriteCloser are
+	// compressed. The caller must Close it to flush any trailer.
+	Compress(w io.Writer) (io.WriteCloser, error)
+	// Decompress wraps r so reads from the returned ReadCloser are
+	// decompressed.
+	Decompress(r io.Reader) (io.ReadCloser, error)
+	// MediaType is the canonical OCI media type for this Type, e.g.
+	// "application/vnd.oci.image.layer.v1.tar+gzip".
+	MediaType() string
+	// ParseMediaType reports whether mediaType (including vendor
+	// aliases, e.g. Docker's schema2 layer types) should be handled by
+	// this Type.
+	ParseMediaType(mediaType string) bool
+	// DetectStream peeks at the head of r and reports whether its
+	// content matches this Type's magic bytes.
+	DetectStream(r *bufio.Reader) (bool, error)
+}
+
+// Uncompressed, Gzip and Zstd are the built-in Types, registered by
+// default and returned by FromMediaType for their respective media
+// types. Estargz is not registered by default, since on the wire it is
+// just gzip; it is only returned by DetectLayerMediaType's TOC probe or
+// used by callers that reference it directly.
+var (
+	Uncompressed Type = uncompressedType{}
+	Gzip         Type = gzipType{}
+	Zstd         Type = zstdType{}
+	Estargz      Type = estargzType{}
+)
+
+var (
+	mu       sync.RWMutex
+	registry = []Type{Zstd, Gzip, Uncompressed}
+)
+
+// Register adds t ahead of the currently registered types, so packages
+// outside compression can add formats (or override how an existing
+// media type is resolved) without modifying this package.
+func Register(t Type) {
+	mu.Lock()
+	defer mu.Unlock()
+	registry = append([]Type{t}, registry...)
+}
+
+// FromMediaType resolves the registered Type responsible for mediaType.
+func FromMediaType(mediaType string) (Type, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	for _, t := range registry {
+		if t.ParseMediaType(mediaType) {
+			return t, nil
+		}
+	}
+	return nil, fmt.Errorf("unsupported layer media type: %s", mediaType)
+}
+
+type uncompressedType struct{}
+
+func (uncompressedType) Compress(w io.Writer) (io.WriteCloser, error) {
+	return nopWriteCloser{w}, nil
+}
+
+func (uncompressedType) Decompress(r io.Reader) (io.ReadCloser, error) {
+	return io.NopCloser(r), nil
+}
+
+func (uncompressedType) MediaType() string { return ocispecs.MediaTypeImageLayer }
+
+func (uncompressedType) ParseMediaType(mediaType string) bool {
+	switch mediaType {
+	case ocispecs.MediaTypeImageLayer, images.MediaTypeDockerSchema2Layer:
+		return true
+	}
+	return false
+}
+
+func (uncompressedType) DetectStream(*bufio.Reader) (bool, error) {
+	// Any content matches, so this only tells formats apart when tried
+	// after every other Type has failed to match. DetectLayerMediaType
+	// does not call it; it checks for the tar magic instead.
+	return true, nil
+}
+
+type gzipType struct{}
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+func (gzipType) Compress(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriter(w), nil
+}
+
+func (gzipType) Decompress(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+func (gzipType) MediaType() string { return ocispecs.MediaTypeImageLayerGzip }
+
+func (gzipType) ParseMediaType(mediaType string) bool {
+	switch mediaType {
+	case ocispecs.MediaTypeImageLayerGzip, images.MediaTypeDockerSchema2LayerGzip:
+		return true
+	}
+	return false
+}
+
+func (gzipType) DetectStream(r *bufio.Reader) (bool, error) {
+	head, err := r.Peek(len(gzipMagic))
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	return bytes.HasPrefix(head, gzipMagic), nil
+}
+
+type zstdType struct{}
+
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+func (zstdType) Compress(w io.Writer) (io.WriteCloser, error) {
+	return zstd.NewWriter(w)
+}
+
+func (zstdType) Decompress(r io.Reader) (io.ReadCloser, error) {
+	zr, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return zstdReadCloser{zr}, nil
+}
+
+func (zstdType) MediaType() string { return ocispecs.MediaTypeImageLayerZstd }
+
+func (zstdType) ParseMediaType(mediaType string) bool {
+	switch mediaType {
+	// containerd doesn't know about vnd.docker.image.rootfs.diff.tar.zstd,
+	// but that media type is wire-compatible with the OCI one.
+	case ocispecs.MediaTypeImageLayerZstd, images.MediaTypeDockerSchema2Layer + ".zstd":
+		return true
+	}
+	return false
+}
+
+func (zstdType) DetectStream(r *bufio.Reader) (bool, error) {
+	head, err := r.Peek(len(zstdMagic))
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	return bytes.HasPrefix(head, zstdMagic), nil
+}
+
+// estargzType re-uses gzip's wire format: estargz is a normal gzip
+// stream with a table of contents appended as trailing gzip members, so
+// a plain gzip reader can read it back. Identifying a stream as estargz
+// rather than plain gzip requires probing for that trailing TOC (see
+// DetectLayerMediaType), not just sniffing the head.
+type estargzType struct{}
+
+func (estargzType) Compress(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriter(w), nil
+}
+
+func (estargzType) Decompress(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+func (estargzType) MediaType() string { return ocispecs.MediaTypeImageLayerGzip }
+
+func (estargzType) ParseMediaType(string) bool {
+	// estargz has no media type of its own; it is identified from
+	// content, not declared up front, so it is deliberately excluded
+	// from FromMediaType's registry.
+	return false
+}
+
+func (estargzType) DetectStream(*bufio.Reader) (bool, error) {
+	return false, nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+type zstdReadCloser struct {
+	*zstd.Decoder
+}
+
+func (z zstdReadCloser) Close() error {
+	z.Decoder.Close()
+	return nil
+}
@@ -0,0 +1,65 @@
+package compression
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/containerd/containerd/v2/core/images"
+	ocispecs "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestFromMediaType(t *testing.T) {
+	for _, tc := range []struct {
+		mediaType string
+		want      Type
+	}{
+		{ocispecs.MediaTypeImageLayer, Uncompressed},
+		{images.MediaTypeDockerSchema2Layer, Uncompressed},
+		{ocispecs.MediaTypeImageLayerGzip, Gzip},
+		{images.MediaTypeDockerSchema2LayerGzip, Gzip},
+		{ocispecs.MediaTypeImageLayerZstd, Zstd},
+		{images.MediaTypeDockerSchema2Layer + ".zstd", Zstd},
+	} {
+		got, err := FromMediaType(tc.mediaType)
+		if err != nil {
+			t.Errorf("FromMediaType(%q): %v", tc.mediaType, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("FromMediaType(%q) = %v, want %v", tc.mediaType, got, tc.want)
+		}
+	}
+
+	if _, err := FromMediaType("application/x-bogus"); err == nil {
+		t.Error("expected an error for an unknown media type")
+	}
+}
+
+func TestGzipCompressDecompressRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	cw, err := Gzip.Compress(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cw.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := cw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	dr, err := Gzip.Decompress(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dr.Close()
+
+	got, err := io.ReadAll(dr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "hello" {
+		t.Errorf("round trip = %q, want %q", got, "hello")
+	}
+}
@@ -0,0 +1,94 @@
+package compression
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/containerd/containerd/v2/core/content"
+	"github.com/containerd/containerd/v2/core/images"
+	ocispecs "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// tarHeaderSize is the size of a single tar header block; an
+// uncompressed tar archive's magic ("ustar") sits 257 bytes into it.
+const tarHeaderSize = 512
+
+// estargzFooterSize is the size of the trailing gzip member stargz
+// appends to encode the offset of its table of contents; see
+// github.com/containerd/stargz-snapshotter/estargz's FooterSize.
+const estargzFooterSize = 51
+
+var estargzFooterMagic = []byte("STARGZ")
+
+// DetectLayerMediaType peeks at ra's content to determine its compression
+// and canonical media type for callers that don't already know it, e.g. a
+// layer imported from a plain tar archive via a docker load-style flow.
+// oci selects whether the returned media type uses the OCI or Docker
+// schema2 namespace for gzip and uncompressed content; zstd content is
+// always reported with the OCI zstd media type.
+func DetectLayerMediaType(ctx context.Context, ra content.ReaderAt, oci bool) (string, Type, error) {
+	head := make([]byte, tarHeaderSize)
+	n, err := ra.ReadAt(head, 0)
+	if err != nil && n == 0 {
+		return "", nil, fmt.Errorf("failed to read layer header: %w", err)
+	}
+	head = head[:n]
+
+	if ok, _ := Gzip.DetectStream(bufio.NewReader(bytes.NewReader(head))); ok {
+		if isEstargz(ra) {
+			return layerMediaType(oci, true), Estargz, nil
+		}
+		return layerMediaType(oci, true), Gzip, nil
+	}
+
+	if ok, _ := Zstd.DetectStream(bufio.NewReader(bytes.NewReader(head))); ok {
+		return ocispecs.MediaTypeImageLayerZstd, Zstd, nil
+	}
+
+	if isTar(head) {
+		return layerMediaType(oci, false), Uncompressed, nil
+	}
+
+	return "", nil, fmt.Errorf("unrecognized layer content")
+}
+
+// isTar reports whether head looks like the start of an uncompressed tar
+// archive, by checking for the POSIX ustar magic 257 bytes in.
+func isTar(head []byte) bool {
+	const (
+		ustarOffset = 257
+		ustarMagic  = "ustar"
+	)
+	return len(head) >= ustarOffset+len(ustarMagic) &&
+		bytes.Equal(head[ustarOffset:ustarOffset+len(ustarMagic)], []byte(ustarMagic))
+}
+
+// isEstargz probes for the stargz table-of-contents footer, a fixed-size
+// gzip member appended after the real content whose extra field encodes
+// the TOC's offset, rather than trying to infer it from the head.
+func isEstargz(ra content.ReaderAt) bool {
+	size := ra.Size()
+	if size < estargzFooterSize {
+		return false
+	}
+	footer := make([]byte, estargzFooterSize)
+	if _, err := ra.ReadAt(footer, size-estargzFooterSize); err != nil {
+		return false
+	}
+	return bytes.HasPrefix(footer, gzipMagic) && bytes.Contains(footer, estargzFooterMagic)
+}
+
+func layerMediaType(oci, gzip bool) string {
+	switch {
+	case oci && gzip:
+		return ocispecs.MediaTypeImageLayerGzip
+	case oci:
+		return ocispecs.MediaTypeImageLayer
+	case gzip:
+		return images.MediaTypeDockerSchema2LayerGzip
+	default:
+		return images.MediaTypeDockerSchema2Layer
+	}
+}
@@ -0,0 +1,79 @@
+package compression
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"testing"
+)
+
+// fakeReaderAt adapts a byte slice to content.ReaderAt for tests.
+type fakeReaderAt struct {
+	*bytes.Reader
+}
+
+func (fakeReaderAt) Close() error { return nil }
+
+func newFakeReaderAt(b []byte) fakeReaderAt {
+	return fakeReaderAt{bytes.NewReader(b)}
+}
+
+func tarBytes(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := tw.WriteHeader(&tar.Header{Name: "file.txt", Size: 5, Mode: 0o644}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDetectLayerMediaTypeUncompressed(t *testing.T) {
+	mt, typ, err := DetectLayerMediaType(context.Background(), newFakeReaderAt(tarBytes(t)), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ != Uncompressed {
+		t.Errorf("type = %v, want Uncompressed", typ)
+	}
+	if mt != "application/vnd.oci.image.layer.v1.tar" {
+		t.Errorf("media type = %q", mt)
+	}
+}
+
+func TestDetectLayerMediaTypeGzip(t *testing.T) {
+	var buf bytes.Buffer
+	cw, err := Gzip.Compress(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cw.Write(tarBytes(t)); err != nil {
+		t.Fatal(err)
+	}
+	if err := cw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	mt, typ, err := DetectLayerMediaType(context.Background(), newFakeReaderAt(buf.Bytes()), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ != Gzip {
+		t.Errorf("type = %v, want Gzip", typ)
+	}
+	if mt != "application/vnd.oci.image.layer.v1.tar+gzip" {
+		t.Errorf("media type = %q", mt)
+	}
+}
+
+func TestDetectLayerMediaTypeUnrecognized(t *testing.T) {
+	if _, _, err := DetectLayerMediaType(context.Background(), newFakeReaderAt([]byte("not a layer")), true); err == nil {
+		t.Error("expected an error for unrecognized content")
+	}
+}
@@ -3,24 +3,26 @@ package winlayers
 import (
 	"archive/tar"
 	"context"
+	"fmt"
 	"io"
+	"path/filepath"
 	"runtime"
 	"strings"
 	"sync"
 
 	"github.com/containerd/containerd/v2/core/content"
 	"github.com/containerd/containerd/v2/core/diff"
-	"github.com/containerd/containerd/v2/core/images"
 	"github.com/containerd/containerd/v2/core/mount"
 	"github.com/containerd/containerd/v2/pkg/archive"
 	"github.com/containerd/containerd/v2/pkg/archive/compression"
 	cerrdefs "github.com/containerd/errdefs"
+	typeurl "github.com/containerd/typeurl/v2"
 	digest "github.com/opencontainers/go-digest"
 	ocispecs "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/pkg/errors"
 )
 
-func NewFileSystemApplierWithWindows(cs content.Provider, a diff.Applier) diff.Applier {
+func NewFileSystemApplierWithWindows(cs content.Store, a diff.Applier) diff.Applier {
 	if runtime.GOOS == "windows" {
 		return a
 	}
@@ -32,74 +34,143 @@ func NewFileSystemApplierWithWindows(cs content.Provider, a diff.Applier) diff.A
 }
 
 type winApplier struct {
-	cs content.Provider
+	cs content.Store
 	a  diff.Applier
 }
 
 func (s *winApplier) Apply(ctx context.Context, desc ocispecs.Descriptor, mounts []mount.Mount, opts ...diff.ApplyOpt) (d ocispecs.Descriptor, err error) {
-	// HACK:, containerd doesn't know about vnd.docker.image.rootfs.diff.tar.zstd, but that
-	// media type is compatible w/ the oci type, so just lie and say it's the oci type
-	if desc.MediaType == images.MediaTypeDockerSchema2Layer+".zstd" {
-		desc.MediaType = ocispecs.MediaTypeImageLayerZstd
-	}
-
 	if !hasWindowsLayerMode(ctx) {
 		return s.apply(ctx, desc, mounts, opts...)
 	}
 
-	compressed, err := images.DiffCompression(ctx, desc.MediaType)
+	ra, err := s.cs.ReaderAt(ctx, desc)
 	if err != nil {
-		return ocispecs.Descriptor{}, errors.Wrapf(cerrdefs.ErrNotImplemented, "unsupported diff media type: %v", desc.MediaType)
+		return ocispecs.Descriptor{}, errors.Wrap(err, "failed to get reader from content store")
 	}
+	defer ra.Close()
 
-	var ocidesc ocispecs.Descriptor
-	if err := mount.WithTempMount(ctx, mounts, func(root string) error {
-		ra, err := s.cs.ReaderAt(ctx, desc)
-		if err != nil {
-			return errors.Wrap(err, "failed to get reader from content store")
+	// compression.FromMediaType resolves the registered compression.Type
+	// for desc.MediaType, including vendor aliases such as Docker's
+	// "+.zstd" schema2 layer type, so we no longer need to special-case
+	// it here. If the caller didn't supply a media type at all (e.g. a
+	// layer imported from a tar archive via a docker load-style flow),
+	// or supplied one FromMediaType doesn't recognize, fall back to
+	// sniffing the content itself before giving up.
+	var t compression.Type
+	if desc.MediaType == "" {
+		mt, dt, derr := compression.DetectLayerMediaType(ctx, ra, false)
+		if derr != nil {
+			return ocispecs.Descriptor{}, errors.Wrap(derr, "failed to detect diff media type")
+		}
+		desc.MediaType = mt
+		t = dt
+	} else if t, err = compression.FromMediaType(desc.MediaType); err != nil {
+		mt, dt, derr := compression.DetectLayerMediaType(ctx, ra, false)
+		if derr != nil {
+			return ocispecs.Descriptor{}, errors.Wrapf(cerrdefs.ErrNotImplemented, "unsupported diff media type: %v", desc.MediaType)
 		}
-		defer ra.Close()
+		desc.MediaType = mt
+		t = dt
+		err = nil
+	}
 
-		r := content.NewReader(ra)
-		if compressed != "" {
-			ds, err := compression.DecompressStream(r)
-			if err != nil {
-				return err
-			}
-			defer ds.Close()
-			r = ds
+	var config diff.ApplyConfig
+	for _, o := range opts {
+		if err := o(ctx, desc, &config); err != nil {
+			return ocispecs.Descriptor{}, errors.Wrap(err, "failed to apply config")
 		}
+	}
+	outputType, err := resolveOutputCompression(config)
+	if err != nil {
+		return ocispecs.Descriptor{}, err
+	}
 
-		digester := digest.Canonical.Digester()
-		rc := &readCounter{
-			r: io.TeeReader(r, digester.Hash()),
+	var ocidesc ocispecs.Descriptor
+	if err := mount.WithTempMount(ctx, mounts, func(root string) error {
+		r, err := t.Decompress(content.NewReader(ra))
+		if err != nil {
+			return err
 		}
+		defer r.Close()
 
-		rc2, discard := filter(rc, func(hdr *tar.Header) bool {
+		creationTimes := map[string]string{}
+		rc2, discard := filter(r, func(hdr *tar.Header) bool {
 			if after, ok := strings.CutPrefix(hdr.Name, "Files/"); ok {
 				hdr.Name = after
 				hdr.Linkname = strings.TrimPrefix(hdr.Linkname, "Files/")
-				// TODO: could convert the windows PAX headers to xattr here to reuse
-				// the original ones in diff for parent directories and file modifications
+				if v, ok := hdr.PAXRecords[paxCreationTime]; ok {
+					creationTimes[after] = v
+				}
+				windowsPaxToXattrs(hdr)
 				return true
 			}
 			return false
 		})
 
-		if _, err := archive.Apply(ctx, root, rc2); err != nil {
+		// Only the default, uncompressed path existed before output
+		// compression was supported, and it never touched the content
+		// store: the returned descriptor just records what was applied.
+		// Keep that for the uncompressed case so plain applies don't
+		// leave an extra, usually-orphaned blob behind; only commit a
+		// blob to the store when a caller explicitly asked for a
+		// recompressed one via WithOutputCompression, since that's the
+		// only case anyone could want to read it back by digest.
+		var cw content.Writer
+		if outputType != compression.Uncompressed {
+			cw, err = s.cs.Writer(ctx, content.WithRef(fmt.Sprintf("winlayers-apply-%s", desc.Digest)))
+			if err != nil {
+				discard(err)
+				return errors.Wrap(err, "failed to open output writer")
+			}
+			defer cw.Close()
+		}
+
+		digester := digest.Canonical.Digester()
+		var w io.Writer = digester.Hash()
+		if cw != nil {
+			w = io.MultiWriter(cw, digester.Hash())
+		}
+		counter := &writeCounter{w: w}
+
+		cr, err := outputType.Compress(counter)
+		if err != nil {
+			discard(err)
+			return errors.Wrap(err, "failed to open output compressor")
+		}
+
+		if _, err := archive.Apply(ctx, root, io.TeeReader(rc2, cr)); err != nil {
 			discard(err)
+			cr.Close()
 			return err
 		}
 
-		// Read any trailing data
-		if _, err := io.Copy(io.Discard, rc); err != nil {
+		// Best-effort: restore the real filesystem birth time where the
+		// platform supports setting it (see setBirthtime); elsewhere the
+		// xattr stash from windowsPaxToXattrs is the only round trip.
+		for rel, v := range creationTimes {
+			ts, err := parseCreationTime(v)
+			if err != nil {
+				continue
+			}
+			if err := setBirthtime(filepath.Join(root, rel), ts); err != nil && !errors.Is(err, errBirthtimeUnsupported) {
+				return errors.Wrapf(err, "failed to restore creation time for %s", rel)
+			}
+		}
+
+		if err := cr.Close(); err != nil {
 			discard(err)
 			return err
 		}
 
+		if cw != nil {
+			if err := ignoreAlreadyExists(cw.Commit(ctx, counter.c, digester.Digest())); err != nil {
+				return errors.Wrap(err, "failed to commit output diff")
+			}
+		}
+
 		ocidesc = ocispecs.Descriptor{
-			MediaType: ocispecs.MediaTypeImageLayer,
-			Size:      rc.c,
+			MediaType: outputType.MediaType(),
+			Size:      counter.c,
 			Digest:    digester.Digest(),
 		}
 		return nil
@@ -109,23 +180,72 @@ func (s *winApplier) Apply(ctx context.Context, desc ocispecs.Descriptor, mounts
 	return ocidesc, nil
 }
 
-type readCounter struct {
-	r io.Reader
-	c int64
+// resolveOutputCompression reports the compression.Type WithOutputCompression
+// requested via config.ProcessorPayloads, or compression.Uncompressed if
+// the caller didn't set one.
+func resolveOutputCompression(config diff.ApplyConfig) (compression.Type, error) {
+	payload, ok := config.ProcessorPayloads[outputCompressionPayload]
+	if !ok {
+		return compression.Uncompressed, nil
+	}
+	v, err := typeurl.UnmarshalAny(payload)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal output compression option")
+	}
+	// typeurl.UnmarshalAny hands back registered non-proto types as a
+	// pointer to a freshly allocated value.
+	var mt outputCompressionOption
+	switch o := v.(type) {
+	case *outputCompressionOption:
+		mt = *o
+	case outputCompressionOption:
+		mt = o
+	default:
+		return nil, errors.Errorf("unexpected output compression option type: %T", v)
+	}
+	t, err := compression.FromMediaType(string(mt))
+	if err != nil {
+		return nil, errors.Wrapf(err, "unsupported output compression: %v", mt)
+	}
+	return t, nil
 }
 
-func (rc *readCounter) Read(p []byte) (n int, err error) {
-	n, err = rc.r.Read(p)
-	rc.c += int64(n)
-	return
+// ignoreAlreadyExists returns nil if err is nil or reports that the
+// content is already present in the store - e.g. re-applying a shared
+// base layer, an apply retry, or two identical diffs - and err otherwise.
+// containerd's content store returns ErrAlreadyExists from Commit in
+// that case; every containerd Applier/Comparer tolerates it.
+func ignoreAlreadyExists(err error) error {
+	if err != nil && !cerrdefs.IsAlreadyExists(err) {
+		return err
+	}
+	return nil
 }
 
+// defaultFilterBufferSize bounds how much of a single tar entry's body can
+// be in flight between the producer goroutine and the pipe at once.
+const defaultFilterBufferSize = 32 * 1024
+
 func filter(in io.Reader, f func(*tar.Header) bool) (io.Reader, func(error)) {
+	return filterWithBuffer(in, f, defaultFilterBufferSize)
+}
+
+// filterWithBuffer behaves like filter, but copies each kept tar entry's
+// body into the pipe through a bufSize buffer instead of the default-sized
+// one io.Copy picks on its own (skipped entries are drained by io.Discard,
+// which uses its own buffer). The returned discard func cancels the source reader and
+// unblocks a tarWriter write stuck on the (unread) pipe before waiting
+// for the producer goroutine to exit, so callers can never leak it.
+func filterWithBuffer(in io.Reader, f func(*tar.Header) bool, bufSize int) (io.Reader, func(error)) {
 	pr, pw := io.Pipe()
 
 	rc := &readCanceler{Reader: in}
+	buf := make([]byte, bufSize)
+	done := make(chan struct{})
 
 	go func() {
+		defer close(done)
+
 		tarReader := tar.NewReader(rc)
 		tarWriter := tar.NewWriter(pw)
 
@@ -144,13 +264,13 @@ func filter(in io.Reader, f func(*tar.Header) bool) (io.Reader, func(error)) {
 					}
 					if h.Size > 0 {
 						//nolint:gosec // never read into memory
-						if _, err := io.Copy(tarWriter, tarReader); err != nil {
+						if _, err := io.CopyBuffer(tarWriter, tarReader, buf); err != nil {
 							return err
 						}
 					}
 				} else if h.Size > 0 {
 					//nolint:gosec // never read into memory
-					if _, err := io.Copy(io.Discard, tarReader); err != nil {
+					if _, err := io.CopyBuffer(io.Discard, tarReader, buf); err != nil {
 						return err
 					}
 				}
@@ -162,6 +282,9 @@ func filter(in io.Reader, f func(*tar.Header) bool) (io.Reader, func(error)) {
 	discard := func(err error) {
 		rc.cancel(err)
 		pw.CloseWithError(err)
+		// Wait for the goroutine above to observe the cancellation and
+		// exit so Apply never returns with it still running.
+		<-done
 	}
 
 	return pr, discard
@@ -0,0 +1,81 @@
+package winlayers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/containerd/containerd/v2/core/diff"
+	"github.com/containerd/containerd/v2/pkg/archive/compression"
+	cerrdefs "github.com/containerd/errdefs"
+	ocispecs "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// TestResolveOutputCompressionDefault covers the common case: no
+// WithOutputCompression opt applied, so Apply must keep downgrading to an
+// uncompressed layer rather than erroring on the missing payload.
+func TestResolveOutputCompressionDefault(t *testing.T) {
+	got, err := resolveOutputCompression(diff.ApplyConfig{})
+	if err != nil {
+		t.Fatalf("resolveOutputCompression: %v", err)
+	}
+	if got != compression.Uncompressed {
+		t.Errorf("got %v, want compression.Uncompressed", got)
+	}
+}
+
+// TestResolveOutputCompressionWithOutputCompression drives
+// WithOutputCompression's diff.ApplyOpt through the same opt-application
+// and payload-resolution path Apply uses, end to end: marshal via
+// typeurl, thread it through diff.ApplyConfig.ProcessorPayloads, and
+// resolve it back to the same compression.Type via
+// compression.FromMediaType.
+func TestResolveOutputCompressionWithOutputCompression(t *testing.T) {
+	for _, want := range []compression.Type{compression.Gzip, compression.Zstd} {
+		var config diff.ApplyConfig
+		opt := WithOutputCompression(want)
+		if err := opt(context.Background(), ocispecs.Descriptor{}, &config); err != nil {
+			t.Fatalf("WithOutputCompression opt: %v", err)
+		}
+
+		got, err := resolveOutputCompression(config)
+		if err != nil {
+			t.Fatalf("resolveOutputCompression: %v", err)
+		}
+		if got != want {
+			t.Errorf("resolveOutputCompression = %v, want %v", got, want)
+		}
+	}
+}
+
+// TestWithOutputCompressionRejectsEstargz covers the request's estargz
+// case: estargzType.Compress only ever writes plain gzip with no table of
+// contents, so selecting it as output must fail loudly instead of
+// silently handing back a layer mislabeled as estargz.
+func TestWithOutputCompressionRejectsEstargz(t *testing.T) {
+	var config diff.ApplyConfig
+	opt := WithOutputCompression(compression.Estargz)
+	if err := opt(context.Background(), ocispecs.Descriptor{}, &config); err == nil {
+		t.Fatal("expected an error selecting estargz output compression, got nil")
+	}
+}
+
+// TestIgnoreAlreadyExists covers the guard Apply and Compare both need
+// around cw.Commit: containerd's content store returns ErrAlreadyExists
+// whenever the produced digest is already present (e.g. two identical
+// diffs, or an apply retry), and that must not fail the call.
+func TestIgnoreAlreadyExists(t *testing.T) {
+	if err := ignoreAlreadyExists(nil); err != nil {
+		t.Errorf("ignoreAlreadyExists(nil) = %v, want nil", err)
+	}
+	if err := ignoreAlreadyExists(cerrdefs.ErrAlreadyExists); err != nil {
+		t.Errorf("ignoreAlreadyExists(ErrAlreadyExists) = %v, want nil", err)
+	}
+	if err := ignoreAlreadyExists(errors.Wrap(cerrdefs.ErrAlreadyExists, "commit")); err != nil {
+		t.Errorf("ignoreAlreadyExists(wrapped ErrAlreadyExists) = %v, want nil", err)
+	}
+	boom := errors.New("boom")
+	if err := ignoreAlreadyExists(boom); err != boom {
+		t.Errorf("ignoreAlreadyExists(boom) = %v, want boom", err)
+	}
+}
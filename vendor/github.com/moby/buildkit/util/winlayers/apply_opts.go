@@ -0,0 +1,52 @@
+package winlayers
+
+import (
+	"context"
+
+	"github.com/containerd/containerd/v2/core/diff"
+	"github.com/containerd/containerd/v2/pkg/archive/compression"
+	typeurl "github.com/containerd/typeurl/v2"
+	ocispecs "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// outputCompressionPayload is the diff.ApplyConfig.ProcessorPayloads key
+// WithOutputCompression uses to carry the requested compression.Type's
+// media type into winApplier.Apply.
+const outputCompressionPayload = "containerd.io/winlayers.output-compression"
+
+// outputCompressionOption is the Go type registered with typeurl for
+// outputCompressionPayload's value. typeurl/v2 only marshals proto.Message
+// values or types explicitly registered with it; a bare string is
+// neither, so WithOutputCompression needs this named-string wrapper
+// rather than marshaling t.MediaType() directly.
+type outputCompressionOption string
+
+func init() {
+	typeurl.Register(outputCompressionOption(""), "github.com/moby/buildkit/util/winlayers", "OutputCompressionOption")
+}
+
+// WithOutputCompression selects the compression winApplier re-encodes the
+// layer with after stripping the Files/ prefix, instead of always
+// downgrading it to an uncompressed layer. It has no effect outside
+// Windows layer mode.
+//
+// compression.Estargz is rejected: estargzType.Compress only ever writes
+// a plain gzip stream with no table of contents, so honoring it here
+// would silently hand back a layer that claims to be estargz but isn't.
+func WithOutputCompression(t compression.Type) diff.ApplyOpt {
+	return func(_ context.Context, _ ocispecs.Descriptor, c *diff.ApplyConfig) error {
+		if t == compression.Estargz {
+			return errors.New("winlayers: estargz output compression is not supported")
+		}
+		payload, err := typeurl.MarshalAny(outputCompressionOption(t.MediaType()))
+		if err != nil {
+			return errors.Wrap(err, "failed to marshal output compression option")
+		}
+		if c.ProcessorPayloads == nil {
+			c.ProcessorPayloads = map[string]typeurl.Any{}
+		}
+		c.ProcessorPayloads[outputCompressionPayload] = payload
+		return nil
+	}
+}
@@ -0,0 +1,22 @@
+//go:build darwin
+
+package winlayers
+
+import (
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// setBirthtime restores a file's real creation time on Darwin, which
+// supports it via setattrlist's ATTR_CMN_CRTIME, unlike Linux.
+func setBirthtime(path string, ts time.Time) error {
+	attrList := unix.Attrlist{
+		Bitmapcount: unix.ATTR_BIT_MAP_COUNT,
+		Commonattr:  unix.ATTR_CMN_CRTIME,
+	}
+	spec := unix.NsecToTimespec(ts.UnixNano())
+	buf := (*[unsafe.Sizeof(spec)]byte)(unsafe.Pointer(&spec))[:]
+	return unix.Setattrlist(path, &attrList, buf, 0)
+}
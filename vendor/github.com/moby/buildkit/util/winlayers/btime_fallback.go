@@ -0,0 +1,12 @@
+//go:build !windows && !darwin
+
+package winlayers
+
+import "time"
+
+// setBirthtime is a no-op on platforms (Linux, etc.) that have no
+// portable syscall to set a file's creation time: the xattr stash in
+// windowsPaxToXattrs is the only round trip available there.
+func setBirthtime(string, time.Time) error {
+	return errBirthtimeUnsupported
+}
@@ -0,0 +1,16 @@
+//go:build !windows && !darwin
+
+package winlayers
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSetBirthtimeFallbackUnsupported(t *testing.T) {
+	err := setBirthtime(filepath.Join(t.TempDir(), "file.txt"), time.Unix(1700000000, 0))
+	if err != errBirthtimeUnsupported {
+		t.Errorf("setBirthtime = %v, want errBirthtimeUnsupported", err)
+	}
+}
@@ -0,0 +1,170 @@
+package winlayers
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"path/filepath"
+	"runtime"
+
+	"github.com/containerd/containerd/v2/core/content"
+	"github.com/containerd/containerd/v2/core/diff"
+	"github.com/containerd/containerd/v2/core/mount"
+	digest "github.com/opencontainers/go-digest"
+	ocispecs "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+// NewWalkingDiffWithWindows wraps d so that, in Windows layer mode, the
+// produced diff mirrors the shape winApplier expects on apply: every
+// entry nested under "Files/" and carrying whatever Windows PAX metadata
+// is stashed in the xattrs of the corresponding file on the upper mount.
+// Entries whose file has no such xattrs get no Windows PAX records.
+func NewWalkingDiffWithWindows(store content.Store, d diff.Comparer) diff.Comparer {
+	if runtime.GOOS == "windows" {
+		return d
+	}
+
+	return &winComparer{
+		store: store,
+		d:     d,
+	}
+}
+
+type winComparer struct {
+	store content.Store
+	d     diff.Comparer
+}
+
+func (s *winComparer) Compare(ctx context.Context, lower, upper []mount.Mount, opts ...diff.Opt) (desc ocispecs.Descriptor, err error) {
+	if !hasWindowsLayerMode(ctx) {
+		return s.d.Compare(ctx, lower, upper, opts...)
+	}
+
+	var config diff.Config
+	for _, opt := range opts {
+		if err := opt(&config); err != nil {
+			return ocispecs.Descriptor{}, errors.Wrap(err, "failed to apply diff options")
+		}
+	}
+
+	inner, err := s.d.Compare(ctx, lower, upper, diff.WithMediaType(ocispecs.MediaTypeImageLayer))
+	if err != nil {
+		return ocispecs.Descriptor{}, errors.Wrap(err, "failed to compute base diff")
+	}
+	// inner only exists to give us something to rewrap into Windows-layer
+	// shape below; it must not leak into the store as an orphaned blob.
+	defer func() {
+		if derr := s.store.Delete(ctx, inner.Digest); derr != nil && err == nil {
+			err = errors.Wrap(derr, "failed to remove intermediate base diff")
+		}
+	}()
+
+	err = mount.WithTempMount(ctx, upper, func(upperRoot string) error {
+		ra, err := s.store.ReaderAt(ctx, inner)
+		if err != nil {
+			return errors.Wrap(err, "failed to read base diff")
+		}
+		defer ra.Close()
+
+		cw, err := s.store.Writer(ctx, content.WithRef(config.Reference))
+		if err != nil {
+			return errors.Wrap(err, "failed to open windows diff writer")
+		}
+		defer cw.Close()
+
+		digester := digest.Canonical.Digester()
+		counter := &writeCounter{w: io.MultiWriter(cw, digester.Hash())}
+
+		if err := rewriteToWindowsDiff(upperRoot, content.NewReader(ra), counter); err != nil {
+			return err
+		}
+
+		if err := ignoreAlreadyExists(cw.Commit(ctx, counter.c, digester.Digest(), content.WithLabels(config.Labels))); err != nil {
+			return errors.Wrap(err, "failed to commit windows diff")
+		}
+
+		desc = ocispecs.Descriptor{
+			MediaType: ocispecs.MediaTypeImageLayer,
+			Digest:    digester.Digest(),
+			Size:      counter.c,
+		}
+		return nil
+	})
+	if err != nil {
+		return ocispecs.Descriptor{}, err
+	}
+	return desc, nil
+}
+
+// rewriteToWindowsDiff reads the plain layer diff tar in r, whose entries
+// are relative to upperRoot, and writes the Windows-format equivalent to
+// w: every entry nested under "Files/" and carrying whatever Windows PAX
+// metadata is stashed in the xattrs of the corresponding file under
+// upperRoot.
+func rewriteToWindowsDiff(upperRoot string, r io.Reader, w io.Writer) error {
+	tarReader := tar.NewReader(r)
+	tarWriter := tar.NewWriter(w)
+
+	for {
+		hdr, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrap(err, "failed to read base diff entry")
+		}
+
+		if err := xattrsToWindowsPax(filepath.Join(upperRoot, hdr.Name), hdr); err != nil {
+			return errors.Wrapf(err, "failed to read windows metadata for %s", hdr.Name)
+		}
+
+		hdr.Name = "Files/" + hdr.Name
+		if hdr.Typeflag == tar.TypeLink {
+			hdr.Linkname = "Files/" + hdr.Linkname
+		}
+
+		if err := tarWriter.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if hdr.Size > 0 {
+			//nolint:gosec // never read into memory
+			if _, err := io.Copy(tarWriter, tarReader); err != nil {
+				return err
+			}
+		}
+	}
+
+	return tarWriter.Close()
+}
+
+// xattrsToWindowsPax copies any user.MSWINDOWS.*/LIBARCHIVE.* xattrs found
+// on path into hdr.PAXRecords, so a Windows-format Compare can round-trip
+// the metadata winApplier stashed there on the way in.
+func xattrsToWindowsPax(path string, hdr *tar.Header) error {
+	for _, key := range windowsPaxKeys {
+		v, err := getxattr(path, "user."+key)
+		if err != nil {
+			return err
+		}
+		if v == "" {
+			continue
+		}
+		if hdr.PAXRecords == nil {
+			hdr.PAXRecords = map[string]string{}
+		}
+		hdr.PAXRecords[key] = v
+	}
+	return nil
+}
+
+type writeCounter struct {
+	w io.Writer
+	c int64
+}
+
+func (wc *writeCounter) Write(p []byte) (n int, err error) {
+	n, err = wc.w.Write(p)
+	wc.c += int64(n)
+	return
+}
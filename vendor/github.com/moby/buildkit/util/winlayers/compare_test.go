@@ -0,0 +1,95 @@
+package winlayers
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRewriteToWindowsDiff(t *testing.T) {
+	upperRoot := t.TempDir()
+	if err := os.WriteFile(filepath.Join(upperRoot, "file.txt"), []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var in bytes.Buffer
+	tw := tar.NewWriter(&in)
+	hdr := &tar.Header{
+		Name:     "file.txt",
+		Typeflag: tar.TypeReg,
+		Size:     int64(len("hello")),
+		Mode:     0o644,
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := rewriteToWindowsDiff(upperRoot, &in, &out); err != nil {
+		t.Fatalf("rewriteToWindowsDiff: %v", err)
+	}
+
+	tr := tar.NewReader(&out)
+	got, err := tr.Next()
+	if err != nil {
+		t.Fatalf("reading rewritten entry: %v", err)
+	}
+	if want := "Files/file.txt"; got.Name != want {
+		t.Errorf("entry name = %q, want %q", got.Name, want)
+	}
+
+	body, err := io.ReadAll(tr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "hello" {
+		t.Errorf("entry body = %q, want %q", body, "hello")
+	}
+
+	if _, err := tr.Next(); err != io.EOF {
+		t.Errorf("expected a single entry, got err = %v", err)
+	}
+}
+
+// TestRewriteToWindowsDiffDeletion covers a layer that deletes a file: the
+// base diff carries an OCI-style ".wh." whiteout entry with no corresponding
+// path on the upper mount, so probing its xattrs must not fail Compare.
+func TestRewriteToWindowsDiffDeletion(t *testing.T) {
+	upperRoot := t.TempDir()
+
+	var in bytes.Buffer
+	tw := tar.NewWriter(&in)
+	hdr := &tar.Header{
+		Name:     ".wh.deleted.txt",
+		Typeflag: tar.TypeReg,
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := rewriteToWindowsDiff(upperRoot, &in, &out); err != nil {
+		t.Fatalf("rewriteToWindowsDiff: %v", err)
+	}
+
+	tr := tar.NewReader(&out)
+	got, err := tr.Next()
+	if err != nil {
+		t.Fatalf("reading rewritten entry: %v", err)
+	}
+	if want := "Files/.wh.deleted.txt"; got.Name != want {
+		t.Errorf("entry name = %q, want %q", got.Name, want)
+	}
+}
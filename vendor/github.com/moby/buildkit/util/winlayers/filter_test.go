@@ -0,0 +1,56 @@
+package winlayers
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestFilterDiscardNoGoroutineLeak(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	payload := []byte("hello")
+	for i := 0; i < 4; i++ {
+		if err := tw.WriteHeader(&tar.Header{
+			Name: "file", Typeflag: tar.TypeReg, Size: int64(len(payload)), Mode: 0o644,
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	out, discard := filter(bytes.NewReader(buf.Bytes()), func(*tar.Header) bool { return true })
+
+	// Simulate archive.Apply reading a single entry and then bailing out
+	// without draining the rest of the pipe, the way an apply error
+	// mid-stream would.
+	tr := tar.NewReader(out)
+	if _, err := tr.Next(); err != nil {
+		t.Fatalf("reading first entry: %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		discard(errors.New("boom"))
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("discard did not return; producer goroutine leaked")
+	}
+
+	// The pipe should now report the discard error rather than hang.
+	if _, err := io.Copy(io.Discard, out); err == nil {
+		t.Error("expected reads after discard to fail")
+	}
+}
@@ -0,0 +1,71 @@
+package winlayers
+
+import (
+	"archive/tar"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Windows-specific PAX keys that carry metadata with no Linux tar
+// equivalent. Comparer emits them when walking a Windows-mode rootfs;
+// winApplier consumes them when rebuilding one.
+const (
+	paxWindowsFileAttr = "MSWINDOWS.fileattr"
+	paxWindowsRawSD    = "MSWINDOWS.rawsd"
+	paxCreationTime    = "LIBARCHIVE.creationtime"
+)
+
+// windowsPaxKeys are preserved verbatim between a Windows-format layer's
+// PAX records and the xattr namespace used to stash them on a Linux
+// rootfs, so a later Compare can reconstruct the original tar header.
+var windowsPaxKeys = []string{paxWindowsFileAttr, paxWindowsRawSD, paxCreationTime}
+
+// xattrPaxPrefix is the PAX record prefix the tar unpacker in
+// archive.Apply already understands as "write this record's value out as
+// a file xattr" (see the tar spec's SCHILY.xattr.* convention).
+const xattrPaxPrefix = "SCHILY.xattr.user."
+
+// windowsPaxToXattrs rewrites any MSWINDOWS.*/LIBARCHIVE.creationtime PAX
+// records on hdr into SCHILY.xattr.* records, so archive.Apply writes
+// them out as user.MSWINDOWS.*/user.LIBARCHIVE.creationtime xattrs on the
+// extracted file instead of silently dropping them. This lets a later
+// Compare on the same rootfs (see xattrsToWindowsPax) reconstruct the
+// original Windows layer, including parent-directory metadata and ACLs
+// that carry no other representation on Linux. Creation time is stashed
+// the same way, since it is the fallback for platforms where setBirthtime
+// (see below) can't restore the real filesystem birth time.
+func windowsPaxToXattrs(hdr *tar.Header) {
+	for _, key := range windowsPaxKeys {
+		v, ok := hdr.PAXRecords[key]
+		if !ok {
+			continue
+		}
+		hdr.PAXRecords[xattrPaxPrefix+key] = v
+	}
+}
+
+// errBirthtimeUnsupported is returned by setBirthtime on platforms with
+// no syscall to set a file's creation time, e.g. Linux, where the xattr
+// stash above is the only way to preserve it.
+var errBirthtimeUnsupported = errors.New("setting file birth time is not supported on this platform")
+
+// parseCreationTime parses a LIBARCHIVE.creationtime PAX value, which
+// uses the same "seconds[.fractional]" format as the standard mtime/atime
+// PAX records.
+func parseCreationTime(v string) (time.Time, error) {
+	sec, frac, hasFrac := strings.Cut(v, ".")
+	s, err := strconv.ParseInt(sec, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var ns int64
+	if hasFrac {
+		frac = (frac + "000000000")[:9]
+		if ns, err = strconv.ParseInt(frac, 10, 64); err != nil {
+			return time.Time{}, err
+		}
+	}
+	return time.Unix(s, ns), nil
+}
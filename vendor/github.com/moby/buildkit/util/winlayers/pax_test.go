@@ -0,0 +1,78 @@
+//go:build !windows
+
+package winlayers
+
+import (
+	"archive/tar"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// TestWindowsPaxXattrRoundTrip exercises the conversion windowsPaxToXattrs
+// and xattrsToWindowsPax perform on either side of archive.Apply: a
+// Windows-format tar header's PAX records are rewritten into the
+// SCHILY.xattr.* records archive.Apply writes out as real file xattrs, and
+// a later Compare must recover the identical PAX keys and values from
+// those xattrs.
+func TestWindowsPaxXattrRoundTrip(t *testing.T) {
+	hdr := &tar.Header{
+		Name: "file.txt",
+		PAXRecords: map[string]string{
+			paxWindowsFileAttr: "32",
+			paxWindowsRawSD:    "AQAAgBQAAAA...",
+			paxCreationTime:    "1700000000.500000000",
+		},
+	}
+
+	windowsPaxToXattrs(hdr)
+
+	path := filepath.Join(t.TempDir(), "file.txt")
+	if err := os.WriteFile(path, []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate what archive.Apply does when it encounters a SCHILY.xattr.*
+	// PAX record: write it out as a real xattr on the extracted file.
+	for _, key := range windowsPaxKeys {
+		v := hdr.PAXRecords[xattrPaxPrefix+key]
+		if err := unix.Lsetxattr(path, "user."+key, []byte(v), 0); err != nil {
+			t.Fatalf("setting xattr %s: %v", key, err)
+		}
+	}
+
+	got := &tar.Header{Name: "file.txt"}
+	if err := xattrsToWindowsPax(path, got); err != nil {
+		t.Fatal(err)
+	}
+
+	for key, want := range map[string]string{
+		paxWindowsFileAttr: "32",
+		paxWindowsRawSD:    "AQAAgBQAAAA...",
+		paxCreationTime:    "1700000000.500000000",
+	} {
+		if got.PAXRecords[key] != want {
+			t.Errorf("PAX record %s = %q, want %q", key, got.PAXRecords[key], want)
+		}
+	}
+}
+
+func TestParseCreationTime(t *testing.T) {
+	ts, err := parseCreationTime("1700000000.500000000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts.Unix() != 1700000000 || ts.Nanosecond() != 500000000 {
+		t.Errorf("parseCreationTime = %v, want sec=1700000000 nsec=500000000", ts)
+	}
+
+	ts, err = parseCreationTime("1700000000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts.Unix() != 1700000000 || ts.Nanosecond() != 0 {
+		t.Errorf("parseCreationTime = %v, want sec=1700000000 nsec=0", ts)
+	}
+}
@@ -0,0 +1,30 @@
+//go:build !windows
+
+package winlayers
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// getxattr reads a single xattr from path, returning ("", nil) if it is
+// simply not set, or if path itself doesn't exist, so callers don't need
+// to special-case ENODATA/ENOTSUP/ENOENT. The ENOENT case covers
+// whiteout and opaque-dir diff entries, which have no backing file on
+// the upper mount.
+func getxattr(path, name string) (string, error) {
+	sz, err := unix.Lgetxattr(path, name, nil)
+	if err != nil {
+		if err == unix.ENODATA || err == unix.ENOTSUP || err == unix.ENOENT {
+			return "", nil
+		}
+		return "", err
+	}
+	if sz == 0 {
+		return "", nil
+	}
+	buf := make([]byte, sz)
+	if _, err := unix.Lgetxattr(path, name, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}